@@ -0,0 +1,455 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultTimeouts are the built-in deadlines for each MongoDB operation the
+// app runs.
+var defaultTimeouts = map[string]time.Duration{
+	"connect":    10 * time.Second,
+	"ping":       5 * time.Second,
+	"list":       5 * time.Second,
+	"warmup":     30 * time.Second,
+	"indexes":    30 * time.Second,
+	"seed":       30 * time.Second,
+	"disconnect": 10 * time.Second,
+}
+
+// mongoURI is a MongoDB connection string that prints with its password
+// redacted, so it can be logged as part of the configuration.
+type mongoURI string
+
+func (u mongoURI) String() string {
+	parsed, err := url.Parse(string(u))
+	if err != nil {
+		return "<invalid connection string>"
+	}
+	return parsed.Redacted()
+}
+
+// config holds the settings read at startup from the environment and an
+// optional config file.
+type config struct {
+	// MongoURI is the connection string and MongoURISource where it came
+	// from, see configLoader.mongoURI.
+	MongoURI             mongoURI
+	MongoURISource       string
+	PingInterval         time.Duration
+	PingFailureThreshold int
+	MaxResponseItems     int
+	EnsureIndexes        bool
+	SeedFile             string
+	SeedDeadLetter       deadLetterConfig
+	// MinPoolSize overrides the minimum pool size in MongoURI, or is -1 to
+	// keep it. WarmUpConnections defaults to the resulting pool size.
+	MinPoolSize       int
+	WarmUpConnections int
+	// HeartbeatInterval is how often the driver checks each server.
+	// TCPKeepAlive is the keepalive probe period on connections, and
+	// MaxConnIdleTime closes connections idle for longer, before a middlebox
+	// silently drops them. A zero HeartbeatInterval or MaxConnIdleTime keeps
+	// the value in MongoURI, or the driver default if it sets none.
+	HeartbeatInterval time.Duration
+	TCPKeepAlive      time.Duration
+	MaxConnIdleTime   time.Duration
+	// MongoHosts overrides the hosts in MongoURI with the replica set members,
+	// so the driver does not rely on a single seed address.
+	MongoHosts []string
+	// Tags limits listings to products with at least one of these tags.
+	Tags []string
+	// Timeouts bounds each MongoDB operation, keyed by operation name; see
+	// defaultTimeouts.
+	Timeouts map[string]time.Duration
+	// CommandLog logs every command the driver sends, with argument values
+	// redacted.
+	CommandLog bool
+	// PrintFormat selects how listings are printed: "text" for humans or
+	// "json" for structured log entries at PrintLevel.
+	PrintFormat string
+	PrintLevel  slog.Level
+	// InstanceID and Environment tag every log line.
+	InstanceID  string
+	Environment string
+	// NameOverflow is "reject" to refuse seed products with names over
+	// maxNameLength or "truncate" to cut them to fit.
+	NameOverflow string
+	// SeedOrdered stops seeding at the first product the server rejects
+	// instead of inserting every other valid one.
+	SeedOrdered bool
+}
+
+// configLoader reads typed settings, preferring environment variables over
+// values from the config file. It collects every invalid value instead of
+// stopping at the first one.
+type configLoader struct {
+	file map[string]string
+	seen map[string]bool
+	errs []error
+}
+
+// readConfigFile reads a JSON object whose keys are the environment variable
+// names, e.g. {"MONGO_PING_INTERVAL": "30s", "MAX_RESPONSE_ITEMS": 500}.
+// Values must be strings, numbers or booleans; lists such as PRODUCT_TAGS are
+// written as comma-separated strings, as in the environment.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", path, jsonErrorPosition(data, err))
+	}
+	values := make(map[string]string, len(raw))
+	var errs []error
+	for name, v := range raw {
+		var value interface{}
+		if err := json.Unmarshal(v, &value); err != nil {
+			return nil, fmt.Errorf("failed to parse config file %s: %w", path, err)
+		}
+		switch value := value.(type) {
+		case string:
+			values[name] = value
+		case float64, bool:
+			// Keep the number as written, e.g. 1000000 rather than 1e+06.
+			values[name] = string(v)
+		default:
+			errs = append(errs, fmt.Errorf("invalid %s in config file %s: must be a string, number or boolean", name, path))
+		}
+	}
+	return values, errors.Join(errs...)
+}
+
+// lookup returns the value of name, or "" if it is unset. An environment
+// variable that is set wins over the config file even when empty, so setting
+// it to "" restores the built-in default.
+// jsonErrorPosition prefixes syntax and type errors from decoding data with
+// the line and column they occurred at, which encoding/json only gives as a
+// byte offset outside the message.
+func jsonErrorPosition(data []byte, err error) error {
+	var offset int64
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		offset = syntaxErr.Offset
+	case errors.As(err, &typeErr):
+		offset = typeErr.Offset
+	default:
+		return err
+	}
+	read := data[:min(int(offset), len(data))]
+	line := bytes.Count(read, []byte("\n")) + 1
+	column := len(read) - bytes.LastIndexByte(read, '\n') - 1
+	return fmt.Errorf("line %d, column %d: %w", line, column, err)
+}
+
+func (l *configLoader) lookup(name string) string {
+	l.seen[name] = true
+	if v, ok := os.LookupEnv(name); ok {
+		return v
+	}
+	return l.file[name]
+}
+
+func (l *configLoader) string(name, def string) string {
+	if v := l.lookup(name); v != "" {
+		return v
+	}
+	return def
+}
+
+func (l *configLoader) oneOf(name, def string, allowed ...string) string {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	for _, a := range allowed {
+		if v == a {
+			return v
+		}
+	}
+	l.errs = append(l.errs, fmt.Errorf("invalid %s %q: must be one of %s", name, v, strings.Join(allowed, ", ")))
+	return def
+}
+
+func (l *configLoader) level(name string, def slog.Level) slog.Level {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(v)); err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s %q: must be debug, info, warn or error", name, v))
+		return def
+	}
+	return level
+}
+
+func (l *configLoader) list(name string) []string {
+	var items []string
+	for _, item := range strings.Split(l.lookup(name), ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// hostList reads a list of host or host:port entries, checking that each port
+// is a number from 1 to 65535 so a typo fails here rather than at connect.
+func (l *configLoader) hostList(name string) []string {
+	hosts := l.list(name)
+	for _, h := range hosts {
+		if err := validateHostPort(h); err != nil {
+			l.errs = append(l.errs, fmt.Errorf("invalid %s entry %q: %w", name, h, err))
+		}
+	}
+	return hosts
+}
+
+func validateHostPort(hostport string) error {
+	// A bare host, including a bracketed IPv6 address, uses the default port.
+	if strings.HasSuffix(hostport, "]") || !strings.Contains(hostport, ":") {
+		if strings.Trim(hostport, "[]") == "" {
+			return errors.New("missing host")
+		}
+		return nil
+	}
+	host, port, err := net.SplitHostPort(hostport)
+	if err != nil {
+		return err
+	}
+	if host == "" {
+		return errors.New("missing host")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("port %q must be a number from 1 to 65535", port)
+	}
+	return nil
+}
+
+// timeouts resolves the deadline of every operation in defaultTimeouts. An
+// entry in the name list, e.g. "list=10s,seed=1m", wins; otherwise the
+// globalName duration applies if set, else the built-in default.
+func (l *configLoader) timeouts(name, globalName string) map[string]time.Duration {
+	global := l.duration(globalName, 0)
+	timeouts := make(map[string]time.Duration, len(defaultTimeouts))
+	for op, d := range defaultTimeouts {
+		if global > 0 {
+			d = global
+		}
+		timeouts[op] = d
+	}
+	for _, entry := range l.list(name) {
+		op, v, _ := strings.Cut(entry, "=")
+		op = strings.TrimSpace(op)
+		if _, ok := defaultTimeouts[op]; !ok {
+			l.errs = append(l.errs, fmt.Errorf("invalid %s entry %q: unknown operation %q", name, entry, op))
+			continue
+		}
+		d, err := time.ParseDuration(strings.TrimSpace(v))
+		if err != nil || d <= 0 {
+			l.errs = append(l.errs, fmt.Errorf("invalid %s entry %q: must be operation=positive duration", name, entry))
+			continue
+		}
+		timeouts[op] = d
+	}
+	return timeouts
+}
+
+func (l *configLoader) duration(name string, def time.Duration) time.Duration {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s %q: must be a positive duration", name, v))
+		return def
+	}
+	return d
+}
+
+func (l *configLoader) bool(name string, def bool) bool {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s %q: must be a boolean", name, v))
+		return def
+	}
+	return b
+}
+
+func (l *configLoader) intAtLeast(name string, def, min int) int {
+	v := l.lookup(name)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		l.errs = append(l.errs, fmt.Errorf("invalid %s %q: must be an integer of at least %d", name, v, min))
+		return def
+	}
+	return n
+}
+
+// mongoURI resolves the connection string from the first source that is set,
+// in this order:
+//
+//  1. MONGO_URI, used as is;
+//  2. MONGO_USER and MONGO_PASSWORD, replacing the credentials in the
+//     built-in connection string;
+//  3. the built-in connection string.
+//
+// It returns the connection string and the name of the source it came from.
+func (l *configLoader) mongoURI() (mongoURI, string) {
+	user, password := l.lookup("MONGO_USER"), l.lookup("MONGO_PASSWORD")
+	if v := l.lookup("MONGO_URI"); v != "" {
+		if user != "" || password != "" {
+			return mongoURI(v), "MONGO_URI (MONGO_USER and MONGO_PASSWORD ignored)"
+		}
+		return mongoURI(v), "MONGO_URI"
+	}
+	if user == "" && password == "" {
+		return defaultURI, "built-in default"
+	}
+	if user == "" || password == "" {
+		l.errs = append(l.errs, errors.New("MONGO_USER and MONGO_PASSWORD must be set together"))
+		return defaultURI, "built-in default"
+	}
+	u, err := url.Parse(defaultURI)
+	if err != nil {
+		l.errs = append(l.errs, fmt.Errorf("failed to parse built-in connection string: %w", err))
+		return defaultURI, "built-in default"
+	}
+	u.User = url.UserPassword(user, password)
+	return mongoURI(u.String()), "MONGO_USER/MONGO_PASSWORD"
+}
+
+// defaultInstanceID returns the hostname, which is the container ID under
+// Docker, or a random ID when the hostname is unavailable. The ID is chosen
+// once per process, so reloading the configuration does not change it.
+var defaultInstanceID = sync.OnceValue(func() string {
+	if host, err := os.Hostname(); err == nil && host != "" {
+		return host
+	}
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+})
+
+// loadConfig reads the configuration from the config file at path, if any,
+// and the environment, which takes precedence. It reports every invalid
+// setting at once rather than stopping at the first one.
+func loadConfig(path string) (config, error) {
+	l := configLoader{seen: make(map[string]bool)}
+	if path != "" {
+		file, err := readConfigFile(path)
+		if err != nil {
+			l.errs = append(l.errs, err)
+		}
+		l.file = file
+	}
+	uri, source := l.mongoURI()
+	cfg := config{
+		MongoURI:             uri,
+		MongoURISource:       source,
+		PingInterval:         l.duration("MONGO_PING_INTERVAL", defaultPingInterval),
+		PingFailureThreshold: l.intAtLeast("MONGO_PING_FAILURE_THRESHOLD", defaultPingFailureThreshold, 1),
+		MaxResponseItems:     l.intAtLeast("MAX_RESPONSE_ITEMS", defaultMaxResponseItems, 1),
+		EnsureIndexes:        l.bool("MONGO_ENSURE_INDEXES", false),
+		SeedFile:             l.string("SEED_FILE", ""),
+		SeedOrdered:          l.bool("SEED_ORDERED", false),
+		MinPoolSize:          l.intAtLeast("MONGO_MIN_POOL_SIZE", -1, 0),
+		HeartbeatInterval:    l.duration("MONGO_HEARTBEAT_INTERVAL", 0),
+		TCPKeepAlive:         l.duration("MONGO_TCP_KEEPALIVE", defaultTCPKeepAlive),
+		MaxConnIdleTime:      l.duration("MONGO_MAX_CONN_IDLE_TIME", 0),
+		MongoHosts:           l.hostList("MONGO_HOSTS"),
+		Tags:                 l.list("PRODUCT_TAGS"),
+		Timeouts:             l.timeouts("OPERATION_TIMEOUTS", "OPERATION_TIMEOUT"),
+		CommandLog:           l.bool("MONGO_COMMAND_LOG", false),
+		NameOverflow:         l.oneOf("NAME_OVERFLOW", "reject", "reject", "truncate"),
+		PrintFormat:          l.oneOf("PRINT_FORMAT", "text", "text", "json"),
+		PrintLevel:           l.level("PRINT_LEVEL", slog.LevelInfo),
+		InstanceID:           l.string("INSTANCE_ID", defaultInstanceID()),
+		Environment:          l.string("ENVIRONMENT", ""),
+		SeedDeadLetter: deadLetterConfig{
+			File:       l.string("SEED_DEAD_LETTER_FILE", ""),
+			Collection: l.string("SEED_DEAD_LETTER_COLLECTION", ""),
+		},
+	}
+	// Warm up as many connections as the pool keeps open unless told otherwise.
+	warmUp := 0
+	if minPoolSize := clientOptions(cfg).MinPoolSize; minPoolSize != nil {
+		warmUp = int(*minPoolSize)
+	}
+	cfg.WarmUpConnections = l.intAtLeast("MONGO_WARMUP_CONNECTIONS", warmUp, 0)
+	for name := range l.file {
+		if !l.seen[name] {
+			l.errs = append(l.errs, fmt.Errorf("unknown setting %s in config file %s", name, path))
+		}
+	}
+	return cfg, errors.Join(l.errs...)
+}
+
+// reloadConfig re-reads the configuration on SIGHUP and applies the settings
+// that take effect without a restart: what is listed, how it is printed and
+// the log tags. Changes to any other setting are logged as requiring a
+// restart and the running values are kept. If the new configuration is
+// invalid, nothing changes.
+func reloadConfig(path string, running config) config {
+	next, err := loadConfig(path)
+	if err != nil {
+		log.Printf("Error reloading configuration, keeping current settings: %v", err)
+		return running
+	}
+	applied := running
+	applied.MaxResponseItems = next.MaxResponseItems
+	applied.Tags = next.Tags
+	applied.PrintFormat = next.PrintFormat
+	applied.PrintLevel = next.PrintLevel
+	applied.InstanceID = next.InstanceID
+	applied.Environment = next.Environment
+	setLogPrefix(applied)
+	if changed := changedFields(running, applied); len(changed) > 0 {
+		log.Printf("Reloaded configuration, applied: %s", strings.Join(changed, ", "))
+	} else {
+		log.Printf("Reloaded configuration, no changes to apply")
+	}
+	if pending := changedFields(applied, next); len(pending) > 0 {
+		log.Printf("Restart required to apply: %s", strings.Join(pending, ", "))
+	}
+	return applied
+}
+
+// changedFields returns the names of the config fields that differ.
+func changedFields(a, b config) []string {
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	var names []string
+	for i := 0; i < va.NumField(); i++ {
+		if !reflect.DeepEqual(va.Field(i).Interface(), vb.Field(i).Interface()) {
+			names = append(names, va.Type().Field(i).Name)
+		}
+	}
+	return names
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestReadConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	data := `{"SEED_FILE": "seed.json", "MAX_RESPONSE_ITEMS": 1000000, "MONGO_ENSURE_INDEXES": true,
+		"PRODUCT_TAGS": ["a", "b"], "OPERATION_TIMEOUTS": {"list": "10s"}, "ENVIRONMENT": null}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	values, err := readConfigFile(path)
+	want := map[string]string{"SEED_FILE": "seed.json", "MAX_RESPONSE_ITEMS": "1000000", "MONGO_ENSURE_INDEXES": "true"}
+	if !maps.Equal(values, want) {
+		t.Errorf("values = %q, want %q", values, want)
+	}
+	for _, name := range []string{"PRODUCT_TAGS", "OPERATION_TIMEOUTS", "ENVIRONMENT"} {
+		if err == nil || !strings.Contains(err.Error(), "invalid "+name) {
+			t.Errorf("error = %v, want it to name %s", err, name)
+		}
+	}
+}
+
+func TestMinPoolSizeFromURI(t *testing.T) {
+	tests := []struct {
+		name, minPoolSize string
+		want              uint64
+	}{
+		{"unset keeps the URI value", "", 7},
+		{"set overrides the URI value", "2", 2},
+		{"zero overrides the URI value", "0", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MONGO_URI", "mongodb://127.0.0.1:27017/?minPoolSize=7")
+			t.Setenv("MONGO_MIN_POOL_SIZE", tt.minPoolSize)
+			t.Setenv("MONGO_WARMUP_CONNECTIONS", "")
+			cfg, err := loadConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := clientOptions(cfg).MinPoolSize; got == nil || *got != tt.want {
+				t.Errorf("MinPoolSize = %v, want %d", got, tt.want)
+			}
+			if cfg.WarmUpConnections != int(tt.want) {
+				t.Errorf("WarmUpConnections = %d, want %d", cfg.WarmUpConnections, tt.want)
+			}
+		})
+	}
+}
+
+func TestMonitoringSettingsFromURI(t *testing.T) {
+	tests := []struct {
+		name, heartbeat, idle string
+		wantHeartbeat         time.Duration
+		wantIdle              time.Duration
+	}{
+		{"unset keeps the URI values", "", "", time.Minute, 5 * time.Second},
+		{"set overrides the URI values", "20s", "1m", 20 * time.Second, time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("MONGO_URI", "mongodb://127.0.0.1:27017/?heartbeatFrequencyMS=60000&maxIdleTimeMS=5000")
+			t.Setenv("MONGO_HEARTBEAT_INTERVAL", tt.heartbeat)
+			t.Setenv("MONGO_MAX_CONN_IDLE_TIME", tt.idle)
+			cfg, err := loadConfig("")
+			if err != nil {
+				t.Fatal(err)
+			}
+			opts := clientOptions(cfg)
+			if opts.HeartbeatInterval == nil || *opts.HeartbeatInterval != tt.wantHeartbeat {
+				t.Errorf("HeartbeatInterval = %v, want %v", opts.HeartbeatInterval, tt.wantHeartbeat)
+			}
+			if opts.MaxConnIdleTime == nil || *opts.MaxConnIdleTime != tt.wantIdle {
+				t.Errorf("MaxConnIdleTime = %v, want %v", opts.MaxConnIdleTime, tt.wantIdle)
+			}
+		})
+	}
+}
+
+func TestJSONErrorPosition(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"trailing comma", "[\n  {\"name\": \"a\"},\n  {\"name\": \"b\",}\n]", "line 3, column 16: invalid character '}'"},
+		{"type mismatch", "[\n  {\"tags\": \"a\"}\n]", "line 2, column 14: json: cannot unmarshal string"},
+		{"unexpected end", "[\n  {\"name\": \"a\"", "line 2, column 14: unexpected end of JSON input"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var products []Product
+			err := jsonErrorPosition([]byte(tt.data), json.Unmarshal([]byte(tt.data), &products))
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("error = %v, want it to start with %q", err, tt.want)
+			}
+		})
+	}
+}
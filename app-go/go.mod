@@ -2,7 +2,10 @@ module appgo
 
 go 1.21
 
-require go.mongodb.org/mongo-driver v1.13.1
+require (
+	go.mongodb.org/mongo-driver v1.13.1
+	golang.org/x/text v0.7.0
+)
 
 require (
 	github.com/golang/snappy v0.0.1 // indirect
@@ -14,5 +17,4 @@ require (
 	github.com/youmark/pkcs8 v0.0.0-20181117223130-1be2e3e5546d // indirect
 	golang.org/x/crypto v0.0.0-20220622213112-05595931fe9d // indirect
 	golang.org/x/sync v0.0.0-20220722155255-886fb9371eb4 // indirect
-	golang.org/x/text v0.7.0 // indirect
 )
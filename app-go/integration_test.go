@@ -0,0 +1,226 @@
+//go:build integration
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// Integration tests run against a real MongoDB:
+//
+//	go test -tags integration ./...
+//
+// Each test starts its own single-node replica set with the mongod found on
+// PATH and removes it afterwards. Set MONGO_TEST_URI to use an already running
+// throwaway server instead; the tests drop the products database on it.
+
+// startMongo returns a client for an empty, ephemeral MongoDB, skipping the
+// test if none is available.
+func startMongo(t *testing.T) *mongo.Client {
+	t.Helper()
+	if uri := os.Getenv("MONGO_TEST_URI"); uri != "" {
+		client := connectTestClient(t, uri)
+		if err := client.Database(databaseName).Drop(context.Background()); err != nil {
+			t.Fatalf("dropping %s: %v", databaseName, err)
+		}
+		return client
+	}
+	mongod, err := exec.LookPath("mongod")
+	if err != nil {
+		t.Skip("mongod not found on PATH; install MongoDB or set MONGO_TEST_URI")
+	}
+	port := freePort(t)
+	dir := t.TempDir()
+	cmd := exec.Command(mongod, "--dbpath", dir, "--port", strconv.Itoa(port), "--bind_ip", "127.0.0.1",
+		"--replSet", "rs0", "--logpath", filepath.Join(dir, "mongod.log"))
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("starting mongod: %v", err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	host := fmt.Sprintf("127.0.0.1:%d", port)
+	client := connectTestClient(t, "mongodb://"+host+"/?directConnection=true")
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	// A replica set, unlike a standalone server, returns the operation times
+	// seeding relies on.
+	initiate := bson.D{{Key: "replSetInitiate", Value: bson.D{
+		{Key: "_id", Value: "rs0"},
+		{Key: "members", Value: bson.A{bson.D{{Key: "_id", Value: 0}, {Key: "host", Value: host}}}},
+	}}}
+	for {
+		err := client.Database("admin").RunCommand(ctx, initiate).Err()
+		if err == nil {
+			break
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("initiating replica set: %v (see %s)", err, filepath.Join(dir, "mongod.log"))
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	for {
+		var hello struct {
+			IsWritablePrimary bool `bson:"isWritablePrimary"`
+		}
+		err := client.Database("admin").RunCommand(ctx, bson.D{{Key: "hello", Value: 1}}).Decode(&hello)
+		if err == nil && hello.IsWritablePrimary {
+			return client
+		}
+		if ctx.Err() != nil {
+			t.Fatalf("waiting for primary: %v", err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func connectTestClient(t *testing.T, uri string) *mongo.Client {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI(uri).SetServerSelectionTimeout(5*time.Second))
+	if err != nil {
+		t.Fatalf("mongo.Connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// insertProducts stores fixture products as given, bypassing the validation
+// seeding applies, and returns them with IDs set.
+func insertProducts(t *testing.T, client *mongo.Client, products ...Product) []Product {
+	t.Helper()
+	docs := make([]interface{}, len(products))
+	for i := range products {
+		if products[i].ID.IsZero() {
+			products[i].ID = primitive.NewObjectID()
+		}
+		docs[i] = products[i]
+	}
+	coll := client.Database(databaseName).Collection(collectionName)
+	if _, err := coll.InsertMany(context.Background(), docs); err != nil {
+		t.Fatalf("inserting fixtures: %v", err)
+	}
+	return products
+}
+
+func TestIntegrationListProducts(t *testing.T) {
+	client := startMongo(t)
+	insertProducts(t, client,
+		Product{Name: "Tea", Tags: []string{"drink"}},
+		Product{Name: "Coffee", Tags: []string{"drink", "hot"}},
+		Product{Name: "Bread", Tags: []string{"food"}},
+	)
+	ctx := context.Background()
+
+	list, err := listProducts(ctx, client, listQuery{MaxItems: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Products) != 2 || !list.Truncated {
+		t.Errorf("MaxItems 2 listed %d products, truncated %v; want 2, true", len(list.Products), list.Truncated)
+	}
+
+	list, err = listProducts(ctx, client, listQuery{MaxItems: 10, Tags: []string{" DRINK "}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Products) != 2 || list.Truncated {
+		t.Errorf("tag drink listed %d products, truncated %v; want 2, false", len(list.Products), list.Truncated)
+	}
+}
+
+func TestIntegrationSeedProducts(t *testing.T) {
+	client := startMongo(t)
+	if err := ensureIndexes(client, 30*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	opts := seedOptions{DeadLetter: deadLetterConfig{Collection: "seed_dead_letter"}, Timeout: 30 * time.Second}
+	seed := []Product{{Name: "Café"}, {Name: ""}, {Name: "CAFE"}, {Name: "Tea", Tags: []string{"Drink"}}}
+	token, err := seedProducts(client, seed, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.OperationTime == nil {
+		t.Error("seedProducts returned no operation time")
+	}
+
+	list, err := listProducts(context.Background(), client, listQuery{MaxItems: 10, After: token})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, p := range list.Products {
+		names = append(names, p.Name)
+	}
+	if len(names) != 2 {
+		t.Errorf("seeded %q, want Café and Tea", names)
+	}
+
+	var dead []deadLetter
+	cursor, err := client.Database(databaseName).Collection("seed_dead_letter").Find(context.Background(), bson.M{}, options.Find().SetSort(bson.M{"index": 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cursor.All(context.Background(), &dead); err != nil {
+		t.Fatal(err)
+	}
+	if len(dead) != 2 || dead[0].Index != 1 || dead[1].Index != 2 {
+		t.Errorf("dead letters = %+v, want seed entries 1 and 2", dead)
+	}
+
+	// The collection is no longer empty, so seeding again inserts nothing.
+	if _, err := seedProducts(client, []Product{{Name: "Milk"}}, opts); err != nil {
+		t.Fatal(err)
+	}
+	n, err := client.Database(databaseName).Collection(collectionName).CountDocuments(context.Background(), bson.M{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 2 {
+		t.Errorf("%d products after reseeding, want 2", n)
+	}
+}
+
+func TestIntegrationEnsureIndexes(t *testing.T) {
+	client := startMongo(t)
+	for i := 0; i < 2; i++ {
+		if err := ensureIndexes(client, 30*time.Second); err != nil {
+			t.Fatalf("ensureIndexes run %d: %v", i+1, err)
+		}
+	}
+	coll := client.Database(databaseName).Collection(collectionName)
+	ctx := context.Background()
+	// Products without nameNormalized, like those the Node app creates, are
+	// not constrained by the unique index.
+	insertProducts(t, client, Product{Name: "Tea"}, Product{Name: "Tea"})
+	if _, err := coll.InsertOne(ctx, Product{ID: primitive.NewObjectID(), Name: "Café", NameNormalized: "cafe"}); err != nil {
+		t.Fatal(err)
+	}
+	_, err := coll.InsertOne(ctx, Product{ID: primitive.NewObjectID(), Name: "CAFE", NameNormalized: "cafe"})
+	if !mongo.IsDuplicateKeyError(err) {
+		t.Errorf("inserting a second cafe: %v, want a duplicate key error", err)
+	}
+}
@@ -0,0 +1,221 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/event"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+func clientOptions(cfg config) *options.ClientOptions {
+	opts := options.Client().ApplyURI(string(cfg.MongoURI)).
+		SetDialer(&net.Dialer{KeepAlive: cfg.TCPKeepAlive}).
+		SetServerMonitor(&event.ServerMonitor{ServerDescriptionChanged: logNodeChange})
+	// Only settings given in the environment or config file override the
+	// connection string.
+	if cfg.HeartbeatInterval > 0 {
+		opts.SetHeartbeatInterval(cfg.HeartbeatInterval)
+	}
+	if cfg.MaxConnIdleTime > 0 {
+		opts.SetMaxConnIdleTime(cfg.MaxConnIdleTime)
+	}
+	if cfg.MinPoolSize >= 0 {
+		opts.SetMinPoolSize(uint64(cfg.MinPoolSize))
+	}
+	if len(cfg.MongoHosts) > 0 {
+		opts.SetHosts(cfg.MongoHosts)
+	}
+	if cfg.CommandLog {
+		opts.SetMonitor(&event.CommandMonitor{
+			Started: func(_ context.Context, e *event.CommandStartedEvent) {
+				log.Printf("MongoDB command %d started: %s.%s %s", e.RequestID, e.DatabaseName, e.CommandName, redactCommand(e.Command))
+			},
+			Succeeded: func(_ context.Context, e *event.CommandSucceededEvent) {
+				log.Printf("MongoDB command %d succeeded: %s in %v", e.RequestID, e.CommandName, e.Duration)
+			},
+			Failed: func(_ context.Context, e *event.CommandFailedEvent) {
+				log.Printf("MongoDB command %d failed: %s in %v: %s", e.RequestID, e.CommandName, e.Duration, redactFailure(e.Failure))
+			},
+		})
+	}
+	return opts
+}
+
+// logConnectionSettings logs the pool and monitoring settings the driver will
+// use, whether they come from MongoURI, the environment or its defaults.
+func logConnectionSettings(cfg config) {
+	opts := clientOptions(cfg)
+	heartbeat := defaultHeartbeatInterval
+	if opts.HeartbeatInterval != nil {
+		heartbeat = *opts.HeartbeatInterval
+	}
+	idle := "unlimited"
+	if opts.MaxConnIdleTime != nil && *opts.MaxConnIdleTime > 0 {
+		idle = opts.MaxConnIdleTime.String()
+	}
+	var minPoolSize uint64
+	if opts.MinPoolSize != nil {
+		minPoolSize = *opts.MinPoolSize
+	}
+	log.Printf("MongoDB heartbeat every %v, TCP keepalive every %v, max connection idle time %s, min pool size %d",
+		heartbeat, cfg.TCPKeepAlive, idle, minPoolSize)
+}
+
+// safeCommandFields are command arguments whose document values describe how
+// a command runs rather than the data it touches, so they are logged as is.
+var safeCommandFields = map[string]bool{
+	"lsid":            true,
+	"$clusterTime":    true,
+	"$readPreference": true,
+	"readConcern":     true,
+	"writeConcern":    true,
+	"sort":            true,
+	"projection":      true,
+	"indexes":         true,
+}
+
+// redactCommand renders cmd as extended JSON with every other document or
+// array argument, such as filters and inserted documents, replaced by
+// "REDACTED". Scalar arguments like the collection name and limit are kept.
+func redactCommand(cmd bson.Raw) string {
+	elems, err := cmd.Elements()
+	if err != nil {
+		return "<invalid command>"
+	}
+	redacted := make(bson.D, 0, len(elems))
+	for _, elem := range elems {
+		key, val := elem.Key(), elem.Value()
+		if (val.Type == bson.TypeEmbeddedDocument || val.Type == bson.TypeArray) && !safeCommandFields[key] {
+			redacted = append(redacted, bson.E{Key: key, Value: "REDACTED"})
+			continue
+		}
+		redacted = append(redacted, bson.E{Key: key, Value: val})
+	}
+	out, err := bson.MarshalExtJSON(redacted, false, false)
+	if err != nil {
+		return "<invalid command>"
+	}
+	return string(out)
+}
+
+// redactFailure returns what a failed command event can safely log. Server
+// errors, which the driver formats as "(Name) message", are cut down to the
+// name because the message can quote document values, such as the key in an
+// E11000 duplicate key error; the event carries no numeric code. Network and
+// timeout errors have no name and are returned whole, since they describe the
+// connection rather than any data.
+func redactFailure(failure string) string {
+	if strings.HasPrefix(failure, "(") {
+		if end := strings.IndexByte(failure, ')'); end > 1 {
+			return failure[1:end]
+		}
+	}
+	return strings.TrimSpace(failure)
+}
+
+// logNodeChange logs each replica set member's state transitions, e.g. a
+// secondary becoming primary after a failover or a node going down.
+func logNodeChange(e *event.ServerDescriptionChangedEvent) {
+	prev, next := e.PreviousDescription.Kind, e.NewDescription.Kind
+	if prev == next {
+		return
+	}
+	if err := e.NewDescription.LastError; err != nil {
+		log.Printf("MongoDB node %s is now %s (was %s): %v", e.Address, next, prev, err)
+		return
+	}
+	log.Printf("MongoDB node %s is now %s (was %s)", e.Address, next, prev)
+}
+
+// monitorConnection pings the current client every interval. After threshold
+// consecutive failures it marks the connection unhealthy and replaces the
+// client with a freshly connected one.
+func monitorConnection(ctx context.Context, current *atomic.Pointer[mongo.Client], cfg config) {
+	interval, threshold := cfg.PingInterval, cfg.PingFailureThreshold
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	healthy := true
+	failures := 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts["ping"])
+		err := current.Load().Ping(pingCtx, nil)
+		cancel()
+		if err == nil {
+			failures = 0
+			if !healthy {
+				log.Printf("MongoDB connection is healthy again")
+				healthy = true
+			}
+			continue
+		}
+		failures++
+		log.Printf("Error pinging MongoDB (%d/%d): %v", failures, threshold, err)
+		if failures < threshold {
+			continue
+		}
+		if healthy {
+			log.Printf("MongoDB connection is unhealthy after %d failed pings", failures)
+			healthy = false
+		}
+		reconnect(ctx, current, cfg)
+		failures = 0
+	}
+}
+
+// reconnect replaces the current client with a new one, but only once the new
+// client has answered a ping; mongo.Connect alone does not dial the server.
+func reconnect(ctx context.Context, current *atomic.Pointer[mongo.Client], cfg config) {
+	connectCtx, cancel := context.WithTimeout(ctx, cfg.Timeouts["connect"])
+	defer cancel()
+	client, err := mongo.Connect(connectCtx, clientOptions(cfg))
+	if err != nil {
+		log.Printf("Error reconnecting to MongoDB: %v", err)
+		return
+	}
+	if err := client.Ping(connectCtx, nil); err != nil {
+		log.Printf("Error reconnecting to MongoDB: %v", err)
+		client.Disconnect(context.Background())
+		return
+	}
+	old := current.Swap(client)
+	log.Printf("Reconnected to MongoDB")
+	// A poll may still be using the old client. Disconnect waits for its
+	// in-use connections to be returned, up to the list timeout.
+	disconnectCtx, cancelDisconnect := context.WithTimeout(context.Background(), cfg.Timeouts["list"])
+	defer cancelDisconnect()
+	if err := old.Disconnect(disconnectCtx); err != nil {
+		log.Printf("Error disconnecting previous MongoDB client: %v", err)
+	}
+}
+
+// warmUpPool pings the server n times concurrently, forcing the pool to open
+// up to n connections before the first poll instead of during it.
+func warmUpPool(client *mongo.Client, n int, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = client.Ping(ctx, nil)
+		}(i)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"testing"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestRedactCommand(t *testing.T) {
+	secret := bson.D{{Key: "name", Value: "secret"}}
+	tests := []struct {
+		name string
+		cmd  bson.D
+		want string
+	}{
+		{
+			"find filter",
+			bson.D{
+				{Key: "find", Value: "products"},
+				{Key: "filter", Value: secret},
+				{Key: "sort", Value: bson.D{{Key: "name", Value: 1}}},
+				{Key: "limit", Value: int64(3)},
+				{Key: "lsid", Value: bson.D{{Key: "id", Value: "abc"}}},
+			},
+			`{"find":"products","filter":"REDACTED","sort":{"name":1},"limit":3,"lsid":{"id":"abc"}}`,
+		},
+		{
+			"insert documents",
+			bson.D{
+				{Key: "insert", Value: "products"},
+				{Key: "documents", Value: bson.A{secret}},
+				{Key: "ordered", Value: false},
+			},
+			`{"insert":"products","documents":"REDACTED","ordered":false}`,
+		},
+		{
+			"aggregate pipeline",
+			bson.D{
+				{Key: "aggregate", Value: "products"},
+				{Key: "pipeline", Value: bson.A{bson.D{{Key: "$match", Value: secret}}}},
+				{Key: "cursor", Value: bson.D{}},
+			},
+			`{"aggregate":"products","pipeline":"REDACTED","cursor":"REDACTED"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			raw, err := bson.Marshal(tt.cmd)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := redactCommand(raw); got != tt.want {
+				t.Errorf("redactCommand() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRedactFailure(t *testing.T) {
+	tests := []struct {
+		failure string
+		want    string
+	}{
+		{`(DuplicateKey) E11000 duplicate key error collection: appdb.products index: name_1 dup key: { name: "secret" }`, "DuplicateKey"},
+		{"(Unauthorized) not authorized on appdb", "Unauthorized"},
+		{" connection(127.0.0.1:27017[-3]) incomplete read of message header: EOF", "connection(127.0.0.1:27017[-3]) incomplete read of message header: EOF"},
+		{" context deadline exceeded", "context deadline exceeded"},
+	}
+	for _, tt := range tests {
+		if got := redactFailure(tt.failure); got != tt.want {
+			t.Errorf("redactFailure(%q) = %q, want %q", tt.failure, got, tt.want)
+		}
+	}
+}
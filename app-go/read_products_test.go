@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// unreachableClient returns a client for an address nothing listens on.
+// mongo.Connect does not dial, so this works without a MongoDB server.
+func unreachableClient(t *testing.T) *mongo.Client {
+	t.Helper()
+	client, err := mongo.Connect(context.Background(), options.Client().ApplyURI("mongodb://127.0.0.1:1/?serverSelectionTimeoutMS=30000"))
+	if err != nil {
+		t.Fatalf("mongo.Connect: %v", err)
+	}
+	t.Cleanup(func() { client.Disconnect(context.Background()) })
+	return client
+}
+
+func TestListProductsStopsOnCancelledContext(t *testing.T) {
+	client := unreachableClient(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	start := time.Now()
+	_, err := listProducts(ctx, client, listQuery{MaxItems: 10})
+	elapsed := time.Since(start)
+
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("listProducts error = %v, want context.Canceled", err)
+	}
+	// Server selection would otherwise wait for serverSelectionTimeoutMS.
+	if elapsed > time.Second {
+		t.Fatalf("listProducts took %v after cancellation, want it to stop promptly", elapsed)
+	}
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want errorKind
+	}{
+		{"canceled", context.Canceled, errCanceled},
+		{"wrapped canceled", fmt.Errorf("server selection error: %w", context.Canceled), errCanceled},
+		{"deadline exceeded", context.DeadlineExceeded, errTimeout},
+		{"wrapped driver timeout", fmt.Errorf("find: %w", mongo.CommandError{Code: 50, Name: "MaxTimeMSExpired"}), errTimeout},
+		{"other driver error", mongo.CommandError{Code: 13, Name: "Unauthorized"}, errOther},
+		{"other error", errors.New("boom"), errOther},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyError(tt.err); got != tt.want {
+				t.Errorf("classifyError(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// BenchmarkDecodeProduct measures decoding listings of different sizes, as
+// listProducts does for each document the cursor returns.
+func BenchmarkDecodeProduct(b *testing.B) {
+	for _, n := range []int{1, 100, 1000} {
+		docs := make([][]byte, n)
+		for i := range docs {
+			doc, err := bson.Marshal(Product{
+				ID:        primitive.NewObjectID(),
+				Name:      fmt.Sprintf("Product %d", i),
+				Tags:      []string{"sale", "new"},
+				CreatedAt: time.Now(),
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			docs[i] = doc
+		}
+		b.Run(fmt.Sprintf("products=%d", n), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				for _, doc := range docs {
+					var p Product
+					if err := bson.Unmarshal(doc, &p); err != nil {
+						b.Fatal(err)
+					}
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+	"golang.org/x/text/unicode/norm"
+)
+
+// normalizeProduct trims the name and converts it to Unicode NFC, so that
+// composed and decomposed forms of the same text (e.g. "é" as U+00E9 or as
+// "e" + U+0301) are validated and stored identically. Tags get the same
+// treatment, are lowercased and have blanks and duplicates removed. With
+// truncateName, a name longer than maxNameLength is cut to fit instead of
+// being left for validateProduct to reject; the result reports whether that
+// happened. NameNormalized is derived from the resulting name.
+func normalizeProduct(p *Product, truncateName bool) (truncated bool) {
+	p.Name = norm.NFC.String(strings.TrimSpace(p.Name))
+	if truncateName && utf8.RuneCountInString(p.Name) > maxNameLength {
+		p.Name = strings.TrimSpace(string([]rune(p.Name)[:maxNameLength]))
+		truncated = true
+	}
+	p.NameNormalized = foldName(p.Name)
+	if p.Tags == nil {
+		return truncated
+	}
+	seen := make(map[string]bool, len(p.Tags))
+	tags := make([]string, 0, len(p.Tags))
+	for _, tag := range p.Tags {
+		tag = normalizeTag(tag)
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			tags = append(tags, tag)
+		}
+	}
+	p.Tags = tags
+	return truncated
+}
+
+// foldName returns the key two product names are duplicates under: trimmed,
+// lowercased and with accents removed, so "Café", " cafe" and "CAFE\u0301"
+// all become "cafe".
+func foldName(name string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.TrimSpace(name)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return strings.ToLower(norm.NFC.String(b.String()))
+}
+
+func normalizeTag(tag string) string {
+	return strings.ToLower(norm.NFC.String(strings.TrimSpace(tag)))
+}
+
+func validateProduct(p Product) error {
+	name := strings.TrimSpace(p.Name)
+	if name == "" {
+		return errors.New("name is required")
+	}
+	if utf8.RuneCountInString(name) > maxNameLength {
+		return fmt.Errorf("name must be at most %d characters", maxNameLength)
+	}
+	if len(p.Tags) > maxTags {
+		return fmt.Errorf("at most %d tags are allowed", maxTags)
+	}
+	for _, tag := range p.Tags {
+		if err := validateTag(tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateTag accepts tags of 1 to maxTagLength letters, digits, '-' and '_'.
+func validateTag(tag string) error {
+	if tag == "" || utf8.RuneCountInString(tag) > maxTagLength {
+		return fmt.Errorf("tag %q must be 1 to %d characters", tag, maxTagLength)
+	}
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '-' && r != '_' {
+			return fmt.Errorf("tag %q may only contain letters, digits, '-' and '_'", tag)
+		}
+	}
+	return nil
+}
+
+// loadSeedFile reads a JSON array of products to seed the collection with.
+func loadSeedFile(path string) ([]Product, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SEED_FILE: %w", err)
+	}
+	var products []Product
+	if err := json.Unmarshal(data, &products); err != nil {
+		return nil, fmt.Errorf("failed to parse SEED_FILE %s: %w", path, jsonErrorPosition(data, err))
+	}
+	return products, nil
+}
+
+// seedOptions controls how seed products are inserted.
+type seedOptions struct {
+	DeadLetter deadLetterConfig
+	// TruncateNames cuts overlong names to maxNameLength instead of
+	// rejecting the product.
+	TruncateNames bool
+	// Ordered stops at the first product the server rejects; the rest are
+	// dead-lettered as not inserted.
+	Ordered bool
+	Timeout time.Duration
+}
+
+// seedProducts inserts the valid seed products if the collection is empty, so
+// restarting with the same SEED_FILE never duplicates them. Entries that fail
+// validation or insertion are skipped and sent to the dead-letter
+// destinations. Unless opts.Ordered is set, one rejected product does not stop
+// the others from being inserted. The returned token lets listings wait for
+// the inserted products even when reading from a lagging secondary.
+func seedProducts(client *mongo.Client, products []Product, opts seedOptions) (writeToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+	sess, err := client.StartSession(options.Session().SetCausalConsistency(true))
+	if err != nil {
+		return writeToken{}, err
+	}
+	defer sess.EndSession(context.Background())
+	ctx = mongo.NewSessionContext(ctx, sess)
+	coll := client.Database(databaseName).Collection(collectionName)
+	n, err := coll.CountDocuments(ctx, bson.M{}, options.Count().SetLimit(1))
+	if err != nil {
+		return writeToken{}, err
+	}
+	if n > 0 {
+		log.Printf("Skipping seed: %s.%s already has products", databaseName, collectionName)
+		return writeToken{}, nil
+	}
+	var docs []Product
+	var docIndexes []int
+	var dead []deadLetter
+	// firstByName maps each folded name to the seed entry that has it.
+	firstByName := make(map[string]int, len(products))
+	for i, p := range products {
+		if normalizeProduct(&p, opts.TruncateNames) {
+			log.Printf("Truncated name of seed product %d to %d characters", i, maxNameLength)
+		}
+		if err := validateProduct(p); err != nil {
+			dead = append(dead, deadLetter{Index: i, Product: p, Reason: err.Error()})
+			continue
+		}
+		if first, ok := firstByName[p.NameNormalized]; ok {
+			dead = append(dead, deadLetter{Index: i, Product: p, Reason: fmt.Sprintf("same name as seed product %d", first)})
+			continue
+		}
+		firstByName[p.NameNormalized] = i
+		if p.ID.IsZero() {
+			p.ID = primitive.NewObjectID()
+		}
+		if p.CreatedAt.IsZero() {
+			p.CreatedAt = time.Now()
+		}
+		docs = append(docs, p)
+		docIndexes = append(docIndexes, i)
+	}
+	var insertErr error
+	if len(docs) > 0 {
+		batch := make([]interface{}, len(docs))
+		for i, doc := range docs {
+			batch[i] = doc
+		}
+		_, insertErr = coll.InsertMany(ctx, batch, options.InsertMany().SetOrdered(opts.Ordered))
+	}
+	inserted, failed, err := insertResults(docs, docIndexes, opts.Ordered, insertErr)
+	dead = append(dead, failed...)
+	if err != nil {
+		// ctx may have run out, so the dead-letter writes get their own.
+		dlqCtx, dlqCancel := context.WithTimeout(context.Background(), opts.Timeout)
+		defer dlqCancel()
+		sendToDeadLetter(dlqCtx, client, opts.DeadLetter, dead)
+		return sessionToken(sess), err
+	}
+	log.Printf("Seeded %d products", inserted)
+	if len(dead) > 0 {
+		sendToDeadLetter(ctx, client, opts.DeadLetter, dead)
+	}
+	return sessionToken(sess), nil
+}
+
+// insertResults maps the outcome of inserting docs, the seed entries at
+// docIndexes, to how many were inserted and a dead letter for each of the
+// rest. A BulkWriteException with only write errors names the documents the
+// server rejected; when ordered, the server also skipped every document after
+// the first of them. Any other error, such as a timeout, network or write
+// concern error, leaves it unknown which documents were written, so all of
+// them are dead-lettered as not confirmed and the error is returned.
+func insertResults(docs []Product, docIndexes []int, ordered bool, insertErr error) (inserted int, dead []deadLetter, err error) {
+	var bwe mongo.BulkWriteException
+	if errors.As(insertErr, &bwe) && bwe.WriteConcernError == nil {
+		for _, we := range bwe.WriteErrors {
+			dead = append(dead, deadLetter{Index: docIndexes[we.Index], Product: docs[we.Index], Reason: we.Message})
+		}
+		if ordered && len(bwe.WriteErrors) > 0 {
+			stop := bwe.WriteErrors[0].Index
+			reason := fmt.Sprintf("not inserted: ordered seed stopped at product %d", docIndexes[stop])
+			for j := stop + 1; j < len(docs); j++ {
+				dead = append(dead, deadLetter{Index: docIndexes[j], Product: docs[j], Reason: reason})
+			}
+			return stop, dead, nil
+		}
+		return len(docs) - len(bwe.WriteErrors), dead, nil
+	}
+	if insertErr != nil {
+		for j, doc := range docs {
+			dead = append(dead, deadLetter{Index: docIndexes[j], Product: doc, Reason: "insert not confirmed: " + insertErr.Error()})
+		}
+		return 0, dead, insertErr
+	}
+	return len(docs), nil, nil
+}
+
+// writeToken marks how far a session's writes reached in the cluster's
+// history. A read in another session advanced to it waits until the server
+// it reads from has applied those writes.
+type writeToken struct {
+	OperationTime *primitive.Timestamp
+	ClusterTime   bson.Raw
+}
+
+func sessionToken(sess mongo.Session) writeToken {
+	return writeToken{OperationTime: sess.OperationTime(), ClusterTime: sess.ClusterTime()}
+}
+
+// deadLetterConfig names where seed entries that could not be inserted are
+// recorded. Either, both or neither destination may be set.
+type deadLetterConfig struct {
+	// File is appended to with one JSON object per entry.
+	File string
+	// Collection is a collection in the products database.
+	Collection string
+}
+
+// deadLetter is a seed entry that could not be inserted and why.
+type deadLetter struct {
+	Index    int       `bson:"index" json:"index"`
+	Product  Product   `bson:"product" json:"product"`
+	Reason   string    `bson:"reason" json:"reason"`
+	FailedAt time.Time `bson:"failedAt" json:"failedAt"`
+}
+
+// sendToDeadLetter records the failed entries in every configured destination
+// and logs a summary pointing to them. Without a destination the entries are
+// only logged.
+func sendToDeadLetter(ctx context.Context, client *mongo.Client, dlq deadLetterConfig, dead []deadLetter) {
+	now := time.Now()
+	for i := range dead {
+		dead[i].FailedAt = now
+		log.Printf("Skipping seed product %d: %s", dead[i].Index, dead[i].Reason)
+	}
+	var destinations []string
+	if dlq.File != "" {
+		if err := appendDeadLetterFile(dlq.File, dead); err != nil {
+			log.Printf("Error writing dead-letter file: %v", err)
+		} else {
+			destinations = append(destinations, dlq.File)
+		}
+	}
+	if dlq.Collection != "" {
+		docs := make([]interface{}, len(dead))
+		for i, d := range dead {
+			docs[i] = d
+		}
+		coll := client.Database(databaseName).Collection(dlq.Collection)
+		if _, err := coll.InsertMany(ctx, docs); err != nil {
+			log.Printf("Error writing dead-letter collection: %v", err)
+		} else {
+			destinations = append(destinations, databaseName+"."+dlq.Collection)
+		}
+	}
+	if len(destinations) == 0 {
+		log.Printf("%d seed products failed; set SEED_DEAD_LETTER_FILE or SEED_DEAD_LETTER_COLLECTION to keep them", len(dead))
+		return
+	}
+	log.Printf("%d seed products failed and were dead-lettered to %s", len(dead), strings.Join(destinations, " and "))
+}
+
+func appendDeadLetterFile(path string, dead []deadLetter) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	for _, d := range dead {
+		if err := enc.Encode(d); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+func TestNormalizeProduct(t *testing.T) {
+	tests := []struct {
+		name         string
+		in           Product
+		truncateName bool
+		want         Product
+		wantTrunc    bool
+	}{
+		{"composed name", Product{Name: "Caf\u00e9"}, false, Product{Name: "Caf\u00e9"}, false},
+		{"decomposed name", Product{Name: "Cafe\u0301"}, false, Product{Name: "Caf\u00e9"}, false},
+		{"name trimmed", Product{Name: "  Tea \t"}, false, Product{Name: "Tea"}, false},
+		{"tags trimmed, lowercased and deduplicated", Product{Name: "x", Tags: []string{" A ", "a", ""}}, false, Product{Name: "x", Tags: []string{"a"}}, false},
+		{"decomposed tag", Product{Name: "x", Tags: []string{"Cafe\u0301", "caf\u00e9"}}, false, Product{Name: "x", Tags: []string{"caf\u00e9"}}, false},
+		{"long name kept for rejection", Product{Name: strings.Repeat("a", maxNameLength+1)}, false, Product{Name: strings.Repeat("a", maxNameLength+1)}, false},
+		{"long name truncated", Product{Name: strings.Repeat("\u00e9", maxNameLength+1)}, true, Product{Name: strings.Repeat("\u00e9", maxNameLength)}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.in
+			truncated := normalizeProduct(&p, tt.truncateName)
+			if p.Name != tt.want.Name {
+				t.Errorf("Name = %q, want %q", p.Name, tt.want.Name)
+			}
+			if !slices.Equal(p.Tags, tt.want.Tags) {
+				t.Errorf("Tags = %q, want %q", p.Tags, tt.want.Tags)
+			}
+			if truncated != tt.wantTrunc {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTrunc)
+			}
+		})
+	}
+}
+
+func TestFoldName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"Caf\u00e9", "cafe"},
+		{"  CAFE\u0301 ", "cafe"},
+		{"cafe", "cafe"},
+		{"\u00c5ngstr\u00f6m", "angstrom"},
+		{"A\u030angstro\u0308m", "angstrom"},
+		{"Stra\u00dfe", "stra\u00dfe"},
+		{"\u0130stanbul", "istanbul"},
+		{"日本", "日本"},
+	}
+	for _, tt := range tests {
+		if got := foldName(tt.name); got != tt.want {
+			t.Errorf("foldName(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestInsertResults(t *testing.T) {
+	// Seed entries 0, 2 and 5 failed validation, so the documents sent to
+	// the server are entries 1, 3, 4 and 6.
+	docs := []Product{{Name: "a"}, {Name: "b"}, {Name: "c"}, {Name: "d"}}
+	docIndexes := []int{1, 3, 4, 6}
+	writeErrors := func(indexes ...int) mongo.BulkWriteException {
+		var bwe mongo.BulkWriteException
+		for _, i := range indexes {
+			bwe.WriteErrors = append(bwe.WriteErrors, mongo.BulkWriteError{
+				WriteError: mongo.WriteError{Index: i, Code: 11000, Message: fmt.Sprintf("duplicate %d", i)},
+			})
+		}
+		return bwe
+	}
+	writeConcern := mongo.BulkWriteException{WriteConcernError: &mongo.WriteConcernError{Name: "WriteConcernFailed"}}
+	stopped := "not inserted: ordered seed stopped at product 3"
+	notConfirmed := func(err error) string { return "insert not confirmed: " + err.Error() }
+
+	tests := []struct {
+		name         string
+		ordered      bool
+		insertErr    error
+		wantInserted int
+		wantDead     []deadLetter
+		wantErr      bool
+	}{
+		{"all inserted", false, nil, 4, nil, false},
+		{"unordered write errors", false, writeErrors(1, 3), 2, []deadLetter{
+			{Index: 3, Product: docs[1], Reason: "duplicate 1"},
+			{Index: 6, Product: docs[3], Reason: "duplicate 3"},
+		}, false},
+		{"ordered write error", true, writeErrors(1), 1, []deadLetter{
+			{Index: 3, Product: docs[1], Reason: "duplicate 1"},
+			{Index: 4, Product: docs[2], Reason: stopped},
+			{Index: 6, Product: docs[3], Reason: stopped},
+		}, false},
+		{"ordered write error on the last document", true, writeErrors(3), 3, []deadLetter{
+			{Index: 6, Product: docs[3], Reason: "duplicate 3"},
+		}, false},
+		{"write concern error", false, writeConcern, 0, []deadLetter{
+			{Index: 1, Product: docs[0], Reason: notConfirmed(writeConcern)},
+			{Index: 3, Product: docs[1], Reason: notConfirmed(writeConcern)},
+			{Index: 4, Product: docs[2], Reason: notConfirmed(writeConcern)},
+			{Index: 6, Product: docs[3], Reason: notConfirmed(writeConcern)},
+		}, true},
+		{"timeout", true, context.DeadlineExceeded, 0, []deadLetter{
+			{Index: 1, Product: docs[0], Reason: notConfirmed(context.DeadlineExceeded)},
+			{Index: 3, Product: docs[1], Reason: notConfirmed(context.DeadlineExceeded)},
+			{Index: 4, Product: docs[2], Reason: notConfirmed(context.DeadlineExceeded)},
+			{Index: 6, Product: docs[3], Reason: notConfirmed(context.DeadlineExceeded)},
+		}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inserted, dead, err := insertResults(docs, docIndexes, tt.ordered, tt.insertErr)
+			if inserted != tt.wantInserted {
+				t.Errorf("inserted = %d, want %d", inserted, tt.wantInserted)
+			}
+			if !slices.EqualFunc(dead, tt.wantDead, func(a, b deadLetter) bool {
+				return a.Index == b.Index && a.Product.Name == b.Product.Name && a.Reason == b.Reason
+			}) {
+				t.Errorf("dead = %+v, want %+v", dead, tt.wantDead)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}